@@ -0,0 +1,25 @@
+package idgenerator
+
+import (
+	"context"
+	"time"
+)
+
+// Backend 底层存储抽象，负责提供一个单调递增的计数器，以及Snowflake模式下
+// worker id抢占所需的分布式互斥原语。CachedIDGenerator通过Backend读写数据，
+// 不关心具体存储是Redis、etcd还是Memcached。
+type Backend interface {
+	// IncrBy 对 key 对应的计数器原子地加上 n，返回加后的值。
+	// key 不存在时视为初始值为 0。
+	IncrBy(ctx context.Context, key string, n int64) (int64, error)
+	// Exists 判断key是否已经存在，用于在第一次取号前决定是否需要调用Option.Initializer播种。
+	Exists(ctx context.Context, key string) (bool, error)
+	// SetNX 仅在key不存在时写入value并设置ttl，成功返回true。用于Snowflake worker id抢占。
+	SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+	// Expire 刷新key的ttl，成功(key存在)返回true。用于续约已抢占的worker id。
+	Expire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Delete 删除key，用于主动释放已抢占的worker id。
+	Delete(ctx context.Context, key string) error
+	// Close 释放底层连接等资源。
+	Close() error
+}
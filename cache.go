@@ -1,59 +1,99 @@
 package idgenerator
 
 import (
+	"context"
 	"errors"
-	"strconv"
 	"sync"
 	"time"
-
-	"github.com/gomodule/redigo/redis"
 )
 
-//CachedIDGenerator 缓存ID生成器实体
+// CachedIDGenerator 缓存ID生成器实体
 type CachedIDGenerator struct {
-	//configEntity redis配置
-	configEntity *RedisConfig
-	redisPool    *redis.Pool
+	backend      Backend
 	baseOption   *Option
+	mapLock      sync.RWMutex // 保护mapContainer的并发读写
 	mapContainer map[string]*idContainer
 }
 
-// RedisConfig Redis配置
-type RedisConfig struct {
-	UserName  string
-	Password  string
-	URL       string
-	MaxIdle   int
-	MaxActive int
-}
-
-// idContainer ID容器
-type idContainer struct {
-	lowID      int64
-	highID     int64
-	expiration time.Time
-	option     *Option
-	lock       sync.RWMutex // 加锁
-}
-
 // Option Option
 type Option struct {
 	IncrNum int64 //获取的ID数量
 	Seconds int   //获取到的ID过期时间(s)
 	Model   int   // 模式 默认1 扩展用
+
+	// PrefetchThresholdPercent current号段剩余id占比低于等于该值(0-100)时后台预取下一个号段，默认10
+	PrefetchThresholdPercent int
+	// PrefetchOnTimeExpiry 为true时，current号段已用时间超过Seconds的15%也会触发预取
+	PrefetchOnTimeExpiry bool
+
+	// OnPrefetchStart 后台预取开始时回调，可用于观测/打点
+	OnPrefetchStart func(key string)
+	// OnPrefetchError 后台预取失败时回调
+	OnPrefetchError func(key string, err error)
+	// OnBlockingFetch GetIDByKey因为current/next都不可用而不得不同步阻塞取号段时回调
+	OnBlockingFetch func(key string)
+
+	// Epoch Model为2(Snowflake)时使用的自定义纪元，不设置则使用2020-01-01 UTC
+	Epoch time.Time
+
+	// Initializer 在IDKey第一次被取号、且底层计数器尚不存在时调用，返回值会被当作起始种子写入，
+	// 使第一个分配出去的id为种子+1而不是1，比如迁移后以max(id) from mysql作为种子。
+	Initializer func(ctx context.Context, key string) (int64, error)
+}
+
+// CacheOption New的可选配置项
+type CacheOption func(*CachedIDGenerator)
+
+// WithBaseOption 设置全局Option，不设置时使用defaultOption
+func WithBaseOption(option *Option) CacheOption {
+	return func(cache *CachedIDGenerator) {
+		cache.baseOption = option
+	}
+}
+
+// New 基于传入的Backend创建CachedIDGenerator，Backend可以是RedisBackend、EtcdBackend、MemcachedBackend等实现
+func New(backend Backend, opts ...CacheOption) *CachedIDGenerator {
+	cacheEntity := &CachedIDGenerator{
+		backend:      backend,
+		baseOption:   defaultOption(),
+		mapContainer: make(map[string]*idContainer),
+	}
+	for _, opt := range opts {
+		opt(cacheEntity)
+	}
+	return cacheEntity
+}
+
+// BuildCacheEntity 创建CacheEntity，使用RedisBackend，保留用于兼容已有的Redis接入方式
+func BuildCacheEntity(config *RedisConfig) (*CachedIDGenerator, error) {
+	backend, err := NewRedisBackend(config)
+	if err != nil {
+		return nil, err
+	}
+	return New(backend), nil
 }
 
-//BuildCacheEntity 创建CacheEntity
-func BuildCacheEntity(config *RedisConfig) *CachedIDGenerator {
-	cacheEntity := CachedIDGenerator{}
-	cacheEntity.configEntity = config
-	cacheEntity.redisPool = redisPollInit(&cacheEntity)
-	cacheEntity.baseOption = defaultOption()
-	cacheEntity.mapContainer = make(map[string]*idContainer)
-	return &cacheEntity
+// Close 释放底层Backend持有的连接等资源，并停止所有Snowflake容器的worker id续约
+func (cache *CachedIDGenerator) Close() error {
+	cache.mapLock.RLock()
+	containers := make([]*idContainer, 0, len(cache.mapContainer))
+	for _, container := range cache.mapContainer {
+		containers = append(containers, container)
+	}
+	cache.mapLock.RUnlock()
+
+	for _, container := range containers {
+		container.lock.Lock()
+		snowflake := container.snowflake
+		container.lock.Unlock()
+		if snowflake != nil {
+			_ = snowflake.close()
+		}
+	}
+	return cache.backend.Close()
 }
 
-//SetBaseOption 配置全局参数
+// SetBaseOption 配置全局参数
 func (cache *CachedIDGenerator) SetBaseOption(option *Option) error {
 	err := checkOption(option)
 	if err != nil {
@@ -69,82 +109,106 @@ func (cache *CachedIDGenerator) SetOption(IDKey string, option *Option) error {
 	if err != nil {
 		return err
 	}
+	cache.mapLock.Lock()
+	defer cache.mapLock.Unlock()
 	oldContainer := cache.mapContainer[IDKey]
 	if oldContainer != nil {
+		oldContainer.lock.Lock()
 		oldContainer.option = option
+		oldContainer.lock.Unlock()
 		return nil
 	}
-	newContainer := idContainer{}
-	newContainer.option = option
-	cache.mapContainer[IDKey] = &newContainer
+	cache.mapContainer[IDKey] = &idContainer{
+		cache:  cache,
+		key:    IDKey,
+		option: option,
+	}
 	return nil
 }
 
-// GetIDByKey 获取分布式ID
-func (cache *CachedIDGenerator) GetIDByKey(IDKey string) (int64, error) {
-	mapContainer := cache.mapContainer
-	container := mapContainer[IDKey]
+// getOrCreateContainer 取出IDKey对应的容器，不存在则以baseOption创建
+func (cache *CachedIDGenerator) getOrCreateContainer(IDKey string) *idContainer {
+	cache.mapLock.RLock()
+	container := cache.mapContainer[IDKey]
+	cache.mapLock.RUnlock()
+	if container != nil {
+		return container
+	}
+
+	cache.mapLock.Lock()
+	defer cache.mapLock.Unlock()
+	container = cache.mapContainer[IDKey]
 	if container == nil {
-		container = &idContainer{}
-		container.option = cache.baseOption
+		container = &idContainer{
+			cache:  cache,
+			key:    IDKey,
+			option: cache.baseOption,
+		}
 		cache.mapContainer[IDKey] = container
 	}
-	result, err := cache.getID(IDKey)
+	return container
+}
+
+// GetIDByKey 获取分布式ID
+func (cache *CachedIDGenerator) GetIDByKey(IDKey string) (int64, error) {
+	return cache.GetIDByKeyCtx(context.Background(), IDKey)
+}
+
+// GetIDByKeyCtx 获取分布式ID，当容器需要同步阻塞拉取号段时会遵循ctx的取消/超时
+func (cache *CachedIDGenerator) GetIDByKeyCtx(ctx context.Context, IDKey string) (int64, error) {
+	container := cache.getOrCreateContainer(IDKey)
+	option := container.getOption()
+	if option.Model == 2 {
+		return container.getSnowflakeID(ctx)
+	}
+	result, err := container.getID()
 	if err == errNotInit ||
 		err == errUseUp ||
 		err == errExpiration {
-		err = cache.fetchIDs(IDKey)
-		if err != nil {
-			return 0, err
+		if option.OnBlockingFetch != nil {
+			option.OnBlockingFetch(IDKey)
+		}
+		var seg *segment
+		var fetchErr error
+		if err == errNotInit {
+			seg, fetchErr = cache.fetchInitialSegment(ctx, IDKey, option)
+		} else {
+			seg, fetchErr = cache.fetchSegment(ctx, IDKey, option)
 		}
-		return cache.getID(IDKey)
+		if fetchErr != nil {
+			return 0, fetchErr
+		}
+		container.lock.Lock()
+		container.current = seg
+		container.next = nil
+		container.lock.Unlock()
+		return container.getID()
 	} else if err != nil {
 		return 0, err
 	}
 	return result, nil
 }
 
-// INCRBY counter 30
-func (cache *CachedIDGenerator) fetchIDs(IDKey string) error {
-	mapContainer := cache.mapContainer
-	container := mapContainer[IDKey]
-	conn := cache.redisPool.Get()
-	defer conn.Close()
-	valueGet, err := redis.Int64(conn.Do("INCRBY", IDKey, container.option.IncrNum))
-	if err != nil {
-		return err
+// GetIDs 批量获取n个分布式ID。优先从本地号段中取，号段不够时只为差额发起一次INCRBY，
+// 避免像循环调用GetIDByKey那样产生n次锁争用和最坏情况下n次Redis往返。
+// Model为2(Snowflake)时退化为循环调用单个生成，因为Snowflake本身不消耗远程号段。
+func (cache *CachedIDGenerator) GetIDs(ctx context.Context, IDKey string, n int) ([]int64, error) {
+	if n <= 0 {
+		return nil, errors.New("n必须大于0")
 	}
-	container.lowID = valueGet - container.option.IncrNum + 1
-	container.highID = valueGet
-	if container.option.Seconds > 0 {
-		duration, err := time.ParseDuration(strconv.Itoa(container.option.Seconds) + "s")
-		if err != nil {
-			return err
-		}
-		container.expiration = time.Now().Add(duration)
-	}
-	return nil
-}
-
-// redisPollInit 初始化Redis线程池
-func redisPollInit(cache *CachedIDGenerator) *redis.Pool {
-	configEntity := cache.configEntity
-	return &redis.Pool{
-		MaxIdle:   configEntity.MaxIdle,
-		MaxActive: configEntity.MaxActive,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", configEntity.URL)
-			if err != nil {
-				return nil, err
-			}
-			_, err = c.Do(configEntity.UserName, configEntity.Password)
+	container := cache.getOrCreateContainer(IDKey)
+	if container.getOption().Model == 2 {
+		ids := make([]int64, 0, n)
+		for i := 0; i < n; i++ {
+			id, err := container.getSnowflakeID(ctx)
 			if err != nil {
-				c.Close()
-				return nil, err
+				return ids, err
 			}
-			return c, err
-		},
+			ids = append(ids, id)
+		}
+		return ids, nil
 	}
+	return container.takeBatch(ctx, n)
 }
 
 func defaultOption() *Option {
@@ -152,40 +216,17 @@ func defaultOption() *Option {
 	baseOption.IncrNum = 100
 	baseOption.Seconds = 10
 	baseOption.Model = 1
+	baseOption.PrefetchThresholdPercent = defaultPrefetchThresholdPercent
 	return &baseOption
 }
 
-// GetID 获取分布式ID
-func (cache *CachedIDGenerator) getID(IDkey string) (int64, error) {
-	container := cache.mapContainer[IDkey]
-	container.lock.Lock()
-	defer container.lock.Unlock()
-	if container.lowID == 0 && container.highID == 0 {
-		return 0, errNotInit
-	}
-	if container.option.Seconds == 0 ||
-		(container.option.Seconds > 0 && time.Now().Before(container.expiration)) {
-		if container.lowID < container.highID {
-			id := container.lowID
-			container.lowID = container.lowID + 1
-			return id, nil
-		} else if container.lowID == container.highID {
-			id := container.lowID
-			return id, nil
-		} else {
-			return 0, errUseUp
-		}
-	}
-	return 0, errExpiration
-}
-
 // checkOption 检查option
 func checkOption(option *Option) error {
 	if option.IncrNum < 1 {
 		return errors.New("IncrNum必须大于或等于1")
 	}
-	if option.Model != 1 {
-		return errors.New("Model 必须为1")
+	if option.Model != 1 && option.Model != 2 {
+		return errors.New("Model 必须为1(号段模式)或2(Snowflake模式)")
 	}
 	if option.Seconds < 0 {
 		return errors.New("Seconds 必须大于等于0")
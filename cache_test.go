@@ -0,0 +1,82 @@
+package idgenerator
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// TestGetIDs_ReturnsDistinctIncreasingIDs 验证批量获取的n个id彼此不重复且单调递增
+func TestGetIDs_ReturnsDistinctIncreasingIDs(t *testing.T) {
+	cache := New(newFakeBackend(), WithBaseOption(&Option{IncrNum: 4, Model: 1}))
+	ids, err := cache.GetIDs(context.Background(), "k", 10)
+	if err != nil {
+		t.Fatalf("GetIDs: %v", err)
+	}
+	if len(ids) != 10 {
+		t.Fatalf("GetIDs returned %d ids, want 10", len(ids))
+	}
+	seen := make(map[int64]bool, len(ids))
+	for i, id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate id %d at index %d: %v", id, i, ids)
+		}
+		seen[id] = true
+		if i > 0 && id <= ids[i-1] {
+			t.Fatalf("ids not increasing at index %d: %v", i, ids)
+		}
+	}
+}
+
+// TestGetIDs_InvalidN 验证n<=0时返回参数错误
+func TestGetIDs_InvalidN(t *testing.T) {
+	cache := New(newFakeBackend())
+	if _, err := cache.GetIDs(context.Background(), "k", 0); err == nil {
+		t.Fatal("expected error for n=0")
+	}
+}
+
+// TestGetIDByKey_ConcurrentWithSetOption 并发调用GetIDByKey与SetOption，
+// 配合-race验证container.option的读写都在container.lock下完成
+func TestGetIDByKey_ConcurrentWithSetOption(t *testing.T) {
+	cache := New(newFakeBackend(), WithBaseOption(&Option{IncrNum: 4, Model: 1}))
+	const key = "k"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = cache.GetIDByKey(key)
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = cache.SetOption(key, &Option{IncrNum: int64(2 + i%5), Model: 1})
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestClose_ConcurrentWithGetIDByKey 并发创建容器与Close，配合-race验证Close读取snowflake
+// 字段时不会与getSnowflakeID的写入产生数据竞争
+func TestClose_ConcurrentWithGetIDByKey(t *testing.T) {
+	cache := New(newFakeBackend(), WithBaseOption(&Option{Model: 2}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _ = cache.GetIDByKeyCtx(context.Background(), "k")
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = cache.Close()
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,280 @@
+package idgenerator
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// segment 一个号段 [lowID, highID]，以及它的取号时间和过期时间
+type segment struct {
+	lowID      int64
+	highID     int64
+	fetchedAt  time.Time
+	expiration time.Time
+}
+
+// idContainer ID容器，维护current/next两个号段实现双buffer预取
+type idContainer struct {
+	cache *CachedIDGenerator
+	key   string
+
+	lock sync.Mutex // 加锁，保护current/next/option/snowflake，prefetching单独用原子操作保护
+
+	current *segment
+	next    *segment
+
+	option      *Option
+	prefetching int32 // 原子标记，保证同一个key同时只有一个预取goroutine在跑
+
+	snowflake *snowflakeGenerator // option.Model为2时才会被创建
+}
+
+// getOption 加锁读取当前option，与SetOption的写入共用同一把锁，避免并发读写option产生数据竞争
+func (c *idContainer) getOption() *Option {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.option
+}
+
+// getSnowflakeID Model为2时的取号逻辑，首次调用会抢占一个worker id
+func (c *idContainer) getSnowflakeID(ctx context.Context) (int64, error) {
+	c.lock.Lock()
+	if c.snowflake == nil {
+		g, err := newSnowflakeGenerator(ctx, c.cache.backend, c.key, c.option)
+		if err != nil {
+			c.lock.Unlock()
+			return 0, err
+		}
+		c.snowflake = g
+	}
+	g := c.snowflake
+	c.lock.Unlock()
+	return g.nextID()
+}
+
+// segmentValid 号段是否还在过期时间内
+func segmentValid(seg *segment, option *Option) bool {
+	return option.Seconds == 0 || time.Now().Before(seg.expiration)
+}
+
+// segmentExhausted 号段里的id是否已经发完
+func segmentExhausted(seg *segment) bool {
+	return seg.lowID > seg.highID
+}
+
+// getID 从容器中取一个id，必要时触发后台预取，current/next都不可用时返回errUseUp/errExpiration/errNotInit
+func (c *idContainer) getID() (int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.current == nil {
+		// takeBatch补足差额后可能只预取了next，此时直接切换过来
+		if c.next == nil || !segmentValid(c.next, c.option) {
+			return 0, errNotInit
+		}
+		c.swapLocked()
+	}
+	if !segmentValid(c.current, c.option) {
+		if c.next != nil && segmentValid(c.next, c.option) {
+			c.swapLocked()
+		} else {
+			return 0, errExpiration
+		}
+	}
+	if segmentExhausted(c.current) {
+		if c.next != nil && segmentValid(c.next, c.option) {
+			c.swapLocked()
+		} else {
+			return 0, errUseUp
+		}
+	}
+
+	id := c.current.lowID
+	c.current.lowID++
+
+	if c.next == nil && c.shouldPrefetchLocked() {
+		c.startPrefetchLocked()
+	}
+	return id, nil
+}
+
+// takeBatch 取n个id，优先从current/next号段里取；本地缓冲不够时为差额发起一次INCRBY key needed直接返回，
+// 避免像单个取号那样逐段循环产生n/IncrNum次backend往返。之后按需后台预取一个正常大小的号段填充
+// current/next，使后续的单个取号调用仍然享受双buffer。
+func (c *idContainer) takeBatch(ctx context.Context, n int) ([]int64, error) {
+	ids := make([]int64, 0, n)
+	c.lock.Lock()
+	for len(ids) < n {
+		if c.current != nil && segmentValid(c.current, c.option) && !segmentExhausted(c.current) {
+			ids = append(ids, c.current.lowID)
+			c.current.lowID++
+			continue
+		}
+		if c.next != nil && segmentValid(c.next, c.option) {
+			c.swapLocked()
+			continue
+		}
+		break
+	}
+	needed := n - len(ids)
+	if needed == 0 {
+		if c.next == nil && c.current != nil && c.shouldPrefetchLocked() {
+			c.startPrefetchLocked()
+		}
+		c.lock.Unlock()
+		return ids, nil
+	}
+	wasUninitialized := c.current == nil
+	option := c.option
+	c.lock.Unlock()
+
+	if option.OnBlockingFetch != nil {
+		option.OnBlockingFetch(c.key)
+	}
+	if wasUninitialized {
+		if err := c.cache.seedInitializer(ctx, c.key, option); err != nil {
+			return ids, err
+		}
+	}
+	valueGet, err := c.cache.backend.IncrBy(ctx, c.key, int64(needed))
+	if err != nil {
+		return ids, err
+	}
+	lowID := valueGet - int64(needed) + 1
+	for id := lowID; id <= valueGet; id++ {
+		ids = append(ids, id)
+	}
+
+	c.lock.Lock()
+	if c.next == nil {
+		c.startPrefetchLocked()
+	}
+	c.lock.Unlock()
+	return ids, nil
+}
+
+// swapLocked 将next切换为current，调用方需持有lock
+func (c *idContainer) swapLocked() {
+	c.current = c.next
+	c.next = nil
+}
+
+// shouldPrefetchLocked 判断是否应该对current后台预取下一个号段，调用方需持有lock
+func (c *idContainer) shouldPrefetchLocked() bool {
+	thresholdPercent := c.option.PrefetchThresholdPercent
+	if thresholdPercent <= 0 {
+		thresholdPercent = defaultPrefetchThresholdPercent
+	}
+	return c.remainingPercentLocked() <= thresholdPercent || c.timeExpiredLocked()
+}
+
+// remainingPercentLocked 计算current号段剩余id占比(0-100)
+func (c *idContainer) remainingPercentLocked() int {
+	totalSize := c.option.IncrNum
+	if totalSize <= 0 {
+		return 100
+	}
+	remaining := c.current.highID - c.current.lowID + 1
+	if remaining < 0 {
+		remaining = 0
+	}
+	return int(remaining * 100 / totalSize)
+}
+
+// timeExpiredLocked 判断是否已经消耗了PrefetchOnTimeExpiry配置下的时间占比
+func (c *idContainer) timeExpiredLocked() bool {
+	if !c.option.PrefetchOnTimeExpiry || c.option.Seconds <= 0 {
+		return false
+	}
+	elapsed := time.Since(c.current.fetchedAt)
+	threshold := time.Duration(float64(c.option.Seconds) * prefetchTimeExpiryRatio * float64(time.Second))
+	return elapsed > threshold
+}
+
+// startPrefetchLocked 尝试抢占prefetching标记并在后台拉取下一个号段，调用方需持有lock
+func (c *idContainer) startPrefetchLocked() {
+	if !atomic.CompareAndSwapInt32(&c.prefetching, 0, 1) {
+		return
+	}
+	go c.prefetch(c.option)
+}
+
+// prefetch 后台拉取下一个号段并写入next，结束后释放prefetching标记。option由调用方在持有lock时
+// 取快照传入，避免后台goroutine脱离lock保护直接读取c.option与SetOption产生数据竞争。
+func (c *idContainer) prefetch(option *Option) {
+	defer atomic.StoreInt32(&c.prefetching, 0)
+
+	if option.OnPrefetchStart != nil {
+		option.OnPrefetchStart(c.key)
+	}
+
+	seg, err := c.cache.fetchSegment(context.Background(), c.key, option)
+	if err != nil {
+		if option.OnPrefetchError != nil {
+			option.OnPrefetchError(c.key, err)
+		}
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.next == nil {
+		c.next = seg
+	}
+}
+
+// fetchInitialSegment 容器第一次为IDKey取号段时调用，若配置了Initializer且计数器尚不存在则先播种再取号段
+func (cache *CachedIDGenerator) fetchInitialSegment(ctx context.Context, IDKey string, option *Option) (*segment, error) {
+	if err := cache.seedInitializer(ctx, IDKey, option); err != nil {
+		return nil, err
+	}
+	return cache.fetchSegment(ctx, IDKey, option)
+}
+
+// seedInitializer IDKey对应的计数器尚不存在且配置了Initializer时，以Initializer的返回值为起始种子写入，
+// 供fetchInitialSegment以及takeBatch的单次批量IncrBy共用
+func (cache *CachedIDGenerator) seedInitializer(ctx context.Context, IDKey string, option *Option) error {
+	if option.Initializer == nil {
+		return nil
+	}
+	exists, err := cache.backend.Exists(ctx, IDKey)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	seed, err := option.Initializer(ctx, IDKey)
+	if err != nil {
+		return err
+	}
+	_, err = cache.backend.SetNX(ctx, IDKey, strconv.FormatInt(seed, 10), 0)
+	return err
+}
+
+// fetchSegment 调用backend取一个新号段
+func (cache *CachedIDGenerator) fetchSegment(ctx context.Context, IDKey string, option *Option) (*segment, error) {
+	valueGet, err := cache.backend.IncrBy(ctx, IDKey, option.IncrNum)
+	if err != nil {
+		return nil, err
+	}
+	seg := &segment{
+		lowID:     valueGet - option.IncrNum + 1,
+		highID:    valueGet,
+		fetchedAt: time.Now(),
+	}
+	if option.Seconds > 0 {
+		seg.expiration = seg.fetchedAt.Add(time.Duration(option.Seconds) * time.Second)
+	}
+	return seg, nil
+}
+
+const (
+	// defaultPrefetchThresholdPercent current号段剩余id低于等于该百分比时触发预取
+	defaultPrefetchThresholdPercent = 10
+	// prefetchTimeExpiryRatio PrefetchOnTimeExpiry开启时，已用时间超过Seconds的该比例也触发预取
+	prefetchTimeExpiryRatio = 0.15
+)
@@ -0,0 +1,202 @@
+package idgenerator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestContainer(cache *CachedIDGenerator, key string, incrNum int64) *idContainer {
+	return &idContainer{
+		cache: cache,
+		key:   key,
+		option: &Option{
+			IncrNum:                  incrNum,
+			PrefetchThresholdPercent: defaultPrefetchThresholdPercent,
+		},
+	}
+}
+
+// TestGetID_SwapsToNextOnExhaustion 验证current耗尽后自动切换到已预取好的next
+func TestGetID_SwapsToNextOnExhaustion(t *testing.T) {
+	cache := New(newFakeBackend())
+	c := newTestContainer(cache, "k", 2)
+	c.current = &segment{lowID: 1, highID: 2}
+	c.next = &segment{lowID: 3, highID: 4}
+	// 模拟已有预取在途，避免取完最后一个id时触发的后台预取赶在耗尽断言之前填充next
+	c.prefetching = 1
+
+	for i, want := range []int64{1, 2, 3, 4} {
+		id, err := c.getID()
+		if err != nil {
+			t.Fatalf("getID #%d: %v", i, err)
+		}
+		if id != want {
+			t.Fatalf("getID #%d = %d, want %d", i, id, want)
+		}
+	}
+	if _, err := c.getID(); err != errUseUp {
+		t.Fatalf("getID after exhaustion = %v, want errUseUp", err)
+	}
+}
+
+// TestStartPrefetchLocked_OnlyOnePrefetchInFlight 验证多个goroutine同时触发预取时，
+// prefetching原子标记保证同一时刻只有一次真正的后台拉取在跑
+func TestStartPrefetchLocked_OnlyOnePrefetchInFlight(t *testing.T) {
+	backend := newFakeBackend()
+	cache := New(backend)
+	c := newTestContainer(cache, "k", 10)
+	c.current = &segment{lowID: 1, highID: 10}
+	// 模拟current是此前已经从backend取到的号段，让backend的计数器与之保持一致
+	if _, err := backend.IncrBy(context.Background(), "k", 10); err != nil {
+		t.Fatalf("seed backend: %v", err)
+	}
+	backend.mu.Lock()
+	backend.incrCall = 0 // 重置种子调用计数，只统计后续预取触发的IncrBy次数
+	backend.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.lock.Lock()
+			if c.next == nil {
+				c.startPrefetchLocked()
+			}
+			c.lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.lock.Lock()
+		next := c.next
+		c.lock.Unlock()
+		if next != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	c.lock.Lock()
+	next := c.next
+	c.lock.Unlock()
+	if next == nil {
+		t.Fatal("expected next segment to be prefetched")
+	}
+	if next.lowID != 11 || next.highID != 20 {
+		t.Fatalf("unexpected prefetched segment: %+v", next)
+	}
+
+	backend.mu.Lock()
+	incrCall := backend.incrCall
+	backend.mu.Unlock()
+	if incrCall != 1 {
+		t.Fatalf("expected exactly one IncrBy call from a single in-flight prefetch, got %d", incrCall)
+	}
+}
+
+// TestTakeBatch_ShortfallUsesSingleIncrBy 验证本地缓冲不够时takeBatch只对差额发起一次IncrBy，
+// 而不是按IncrNum分段循环取号段（否则批量取n个id会产生n/IncrNum次backend往返）
+func TestTakeBatch_ShortfallUsesSingleIncrBy(t *testing.T) {
+	backend := newFakeBackend()
+	cache := New(backend)
+	c := newTestContainer(cache, "k", 100)
+	c.current = &segment{lowID: 1, highID: 2}
+	// 模拟current是此前已经从backend取到的号段，让backend的计数器与之保持一致
+	if _, err := backend.IncrBy(context.Background(), "k", 2); err != nil {
+		t.Fatalf("seed backend: %v", err)
+	}
+	backend.mu.Lock()
+	backend.incrCall = 0 // 重置种子调用计数，只统计takeBatch自身触发的IncrBy次数
+	backend.incrCalls = nil
+	backend.mu.Unlock()
+
+	ids, err := c.takeBatch(context.Background(), 1000)
+	if err != nil {
+		t.Fatalf("takeBatch: %v", err)
+	}
+	if len(ids) != 1000 {
+		t.Fatalf("takeBatch returned %d ids, want 1000", len(ids))
+	}
+	for i, id := range ids {
+		if id != int64(i+1) {
+			t.Fatalf("ids[%d] = %d, want %d", i, id, i+1)
+		}
+	}
+
+	backend.mu.Lock()
+	firstCall := backend.incrCalls[0]
+	backend.mu.Unlock()
+	// 差额998个id应由一次IncrBy(key, 998)满足，而不是循环调用IncrNum=100的fetchSegment；
+	// takeBatch返回后可能还会额外触发一次后台预取(IncrNum=100)，但那次调用必须排在这次之后
+	if firstCall != 998 {
+		t.Fatalf("expected the first IncrBy call to cover the full shortfall of 998 in one request, got n=%d", firstCall)
+	}
+}
+
+// TestTakeBatch_BackgroundPrefetchAfterShortfall 验证一次性补足差额后，takeBatch会后台预取一个
+// 正常大小的号段填充next，使后续的单个取号调用仍然享受双buffer，而不是每次都退化为单次IncrBy
+func TestTakeBatch_BackgroundPrefetchAfterShortfall(t *testing.T) {
+	backend := newFakeBackend()
+	cache := New(backend)
+	c := newTestContainer(cache, "k", 10)
+
+	ids, err := c.takeBatch(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("takeBatch: %v", err)
+	}
+	if len(ids) != 3 {
+		t.Fatalf("takeBatch returned %d ids, want 3", len(ids))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.lock.Lock()
+		next := c.next
+		c.lock.Unlock()
+		if next != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.next == nil {
+		t.Fatal("expected a background prefetch to have filled next")
+	}
+	if c.next.lowID != 4 || c.next.highID != 13 {
+		t.Fatalf("unexpected background-prefetched segment: %+v", c.next)
+	}
+}
+
+// TestTakeBatch_ConcurrentWithSetOption 并发调用takeBatch与替换option，配合-race验证option读写同步正确
+func TestTakeBatch_ConcurrentWithSetOption(t *testing.T) {
+	cache := New(newFakeBackend())
+	const key = "k"
+	if err := cache.SetOption(key, &Option{IncrNum: 5, Model: 1}); err != nil {
+		t.Fatalf("SetOption: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			container := cache.getOrCreateContainer(key)
+			_, _ = container.takeBatch(context.Background(), 3)
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = cache.SetOption(key, &Option{IncrNum: int64(5 + i), Model: 1})
+		}(i)
+	}
+	wg.Wait()
+}
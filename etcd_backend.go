@@ -0,0 +1,168 @@
+package idgenerator
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EtcdConfig etcd配置
+type EtcdConfig struct {
+	Endpoints []string
+	Username  string
+	Password  string
+	// LeaseTTL 计数器key绑定的lease TTL(s)，避免进程异常退出后key永久残留
+	LeaseTTL int64
+}
+
+// EtcdBackend 基于etcd v3事务实现的Backend，计数器以一个带lease的key保存
+type EtcdBackend struct {
+	client   *clientv3.Client
+	leaseTTL int64
+
+	leaseMu sync.Mutex
+	leases  map[string]clientv3.LeaseID // SetNX创建的key对应的lease，Expire/Delete时需要用到
+}
+
+// NewEtcdBackend 基于EtcdConfig创建EtcdBackend
+func NewEtcdBackend(config *EtcdConfig) (*EtcdBackend, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints: config.Endpoints,
+		Username:  config.Username,
+		Password:  config.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	leaseTTL := config.LeaseTTL
+	if leaseTTL <= 0 {
+		leaseTTL = 60
+	}
+	return &EtcdBackend{client: client, leaseTTL: leaseTTL, leases: make(map[string]clientv3.LeaseID)}, nil
+}
+
+// IncrBy 通过CAS事务对key对应的计数器加n，key不存在时携带一个lease创建
+func (backend *EtcdBackend) IncrBy(ctx context.Context, key string, n int64) (int64, error) {
+	for {
+		getResp, err := backend.client.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		var current int64
+		var modRevision int64
+		if len(getResp.Kvs) > 0 {
+			current, err = strconv.ParseInt(string(getResp.Kvs[0].Value), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			modRevision = getResp.Kvs[0].ModRevision
+		}
+		newValue := current + n
+		newValueStr := strconv.FormatInt(newValue, 10)
+
+		var putOp clientv3.Op
+		if modRevision == 0 {
+			leaseResp, err := backend.client.Grant(ctx, backend.leaseTTL)
+			if err != nil {
+				return 0, err
+			}
+			putOp = clientv3.OpPut(key, newValueStr, clientv3.WithLease(leaseResp.ID))
+		} else {
+			putOp = clientv3.OpPut(key, newValueStr)
+		}
+
+		txnResp, err := backend.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", modRevision)).
+			Then(putOp).
+			Commit()
+		if err != nil {
+			return 0, err
+		}
+		if txnResp.Succeeded {
+			return newValue, nil
+		}
+		// 事务未成功说明key在读取后被其他节点修改，重试
+	}
+}
+
+// Exists 判断key是否已经存在
+func (backend *EtcdBackend) Exists(ctx context.Context, key string) (bool, error) {
+	getResp, err := backend.client.Get(ctx, key, clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+	return getResp.Count > 0, nil
+}
+
+// SetNX 仅在key不存在时写入value，ttl>0时绑定一个对应TTL的lease，ttl<=0则永久保存(不绑定lease)
+func (backend *EtcdBackend) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	var putOp clientv3.Op
+	var leaseID clientv3.LeaseID
+	if ttl > 0 {
+		leaseResp, err := backend.client.Grant(ctx, int64(ttl.Seconds()))
+		if err != nil {
+			return false, err
+		}
+		leaseID = leaseResp.ID
+		putOp = clientv3.OpPut(key, value, clientv3.WithLease(leaseID))
+	} else {
+		putOp = clientv3.OpPut(key, value)
+	}
+
+	txnResp, err := backend.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", 0)).
+		Then(putOp).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	if !txnResp.Succeeded {
+		if ttl > 0 {
+			_, _ = backend.client.Revoke(ctx, leaseID)
+		}
+		return false, nil
+	}
+	if ttl > 0 {
+		backend.leaseMu.Lock()
+		backend.leases[key] = leaseID
+		backend.leaseMu.Unlock()
+	}
+	return true, nil
+}
+
+// Expire 为key对应的lease续约
+func (backend *EtcdBackend) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	backend.leaseMu.Lock()
+	leaseID, ok := backend.leases[key]
+	backend.leaseMu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	_, err := backend.client.KeepAliveOnce(ctx, leaseID)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete 删除key并撤销其lease
+func (backend *EtcdBackend) Delete(ctx context.Context, key string) error {
+	backend.leaseMu.Lock()
+	leaseID, ok := backend.leases[key]
+	delete(backend.leases, key)
+	backend.leaseMu.Unlock()
+	if ok {
+		_, err := backend.client.Revoke(ctx, leaseID)
+		return err
+	}
+	_, err := backend.client.Delete(ctx, key)
+	return err
+}
+
+// Close 关闭etcd客户端
+func (backend *EtcdBackend) Close() error {
+	return backend.client.Close()
+}
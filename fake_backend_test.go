@@ -0,0 +1,54 @@
+package idgenerator
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// fakeBackend 基于内存map实现的Backend，仅用于测试，并发安全
+type fakeBackend struct {
+	mu        sync.Mutex
+	counters  map[string]int64
+	incrCall  int
+	incrCalls []int64 // 按调用顺序记录每次IncrBy的n参数
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{counters: make(map[string]int64)}
+}
+
+func (b *fakeBackend) IncrBy(ctx context.Context, key string, n int64) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.incrCall++
+	b.incrCalls = append(b.incrCalls, n)
+	b.counters[key] += n
+	return b.counters[key], nil
+}
+
+func (b *fakeBackend) Exists(ctx context.Context, key string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.counters[key]
+	return ok, nil
+}
+
+func (b *fakeBackend) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (b *fakeBackend) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (b *fakeBackend) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.counters, key)
+	return nil
+}
+
+func (b *fakeBackend) Close() error {
+	return nil
+}
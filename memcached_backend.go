@@ -0,0 +1,94 @@
+package idgenerator
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// MemcachedConfig Memcached配置
+type MemcachedConfig struct {
+	Servers []string
+}
+
+// MemcachedBackend 基于gomemcache Increment命令实现的Backend
+type MemcachedBackend struct {
+	client *memcache.Client
+}
+
+// NewMemcachedBackend 基于MemcachedConfig创建MemcachedBackend
+func NewMemcachedBackend(config *MemcachedConfig) *MemcachedBackend {
+	return &MemcachedBackend{client: memcache.New(config.Servers...)}
+}
+
+// IncrBy 对key执行Increment，key不存在时先以n为初始值写入
+func (backend *MemcachedBackend) IncrBy(ctx context.Context, key string, n int64) (int64, error) {
+	newValue, err := backend.client.Increment(key, uint64(n))
+	if err == memcache.ErrCacheMiss {
+		addErr := backend.client.Add(&memcache.Item{Key: key, Value: []byte(strconv.FormatInt(n, 10))})
+		if addErr == nil {
+			return n, nil
+		}
+		if addErr != memcache.ErrNotStored {
+			return 0, addErr
+		}
+		// 写入期间被其他节点抢先创建，重新自增一次
+		newValue, err = backend.client.Increment(key, uint64(n))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(newValue), nil
+}
+
+// Exists 判断key是否已经存在
+func (backend *MemcachedBackend) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := backend.client.Get(key)
+	if err == memcache.ErrCacheMiss {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetNX 仅在key不存在时写入value并设置ttl，ttl<=0表示不设置过期时间
+func (backend *MemcachedBackend) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	err := backend.client.Add(&memcache.Item{Key: key, Value: []byte(value), Expiration: int32(ttl.Seconds())})
+	if err == memcache.ErrNotStored {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Expire 通过Touch命令刷新key的ttl
+func (backend *MemcachedBackend) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	err := backend.client.Touch(key, int32(ttl.Seconds()))
+	if err == memcache.ErrCacheMiss {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete 删除key
+func (backend *MemcachedBackend) Delete(ctx context.Context, key string) error {
+	err := backend.client.Delete(key)
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
+// Close gomemcache的Client不持有需要释放的长连接
+func (backend *MemcachedBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package idgenerator
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	snowflakeWorkerIDBits = 10
+	snowflakeSequenceBits = 12
+
+	snowflakeMaxWorkerID = int64(-1) ^ (int64(-1) << snowflakeWorkerIDBits)
+	snowflakeMaxSequence = int64(-1) ^ (int64(-1) << snowflakeSequenceBits)
+
+	snowflakeTimestampShift = snowflakeWorkerIDBits + snowflakeSequenceBits
+	snowflakeWorkerIDShift  = snowflakeSequenceBits
+
+	// snowflakeWorkerKeyTTL worker id抢占key的TTL，后台ticker会在到期前续约
+	snowflakeWorkerKeyTTL = 30 * time.Second
+	// snowflakeWorkerKeyRefreshInterval worker id续约的ticker间隔
+	snowflakeWorkerKeyRefreshInterval = 10 * time.Second
+)
+
+// defaultSnowflakeEpoch Option.Epoch未设置时使用的默认自定义纪元
+var defaultSnowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	errClockBackwards = errors.New("检测到系统时钟回拨")
+	errNoFreeWorkerID = errors.New("worker id已全部被占用")
+)
+
+// snowflakeGenerator 单个IDKey对应的Snowflake状态，worker id通过backend的SetNX抢占以保证全局唯一
+type snowflakeGenerator struct {
+	epochMs int64
+
+	mu            sync.Mutex
+	workerID      int64
+	lastTimestamp int64
+	sequence      int64
+
+	backend   Backend
+	workerKey string
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// newSnowflakeGenerator 为IDKey抢占一个worker id并启动续约goroutine
+func newSnowflakeGenerator(ctx context.Context, backend Backend, IDKey string, option *Option) (*snowflakeGenerator, error) {
+	epoch := option.Epoch
+	if epoch.IsZero() {
+		epoch = defaultSnowflakeEpoch
+	}
+	g := &snowflakeGenerator{
+		epochMs:       epoch.UnixMilli(),
+		lastTimestamp: -1,
+		backend:       backend,
+		stopCh:        make(chan struct{}),
+	}
+
+	workerID, workerKey, err := claimWorkerID(ctx, backend, IDKey)
+	if err != nil {
+		return nil, err
+	}
+	g.workerID = workerID
+	g.workerKey = workerKey
+
+	go g.keepAliveWorkerID()
+	return g, nil
+}
+
+// claimWorkerID 从0开始扫描，SETNX第一个未被占用的worker id
+func claimWorkerID(ctx context.Context, backend Backend, IDKey string) (int64, string, error) {
+	for id := int64(0); id <= snowflakeMaxWorkerID; id++ {
+		workerKey := IDKey + ":worker:" + strconv.FormatInt(id, 10)
+		ok, err := backend.SetNX(ctx, workerKey, "1", snowflakeWorkerKeyTTL)
+		if err != nil {
+			return 0, "", err
+		}
+		if ok {
+			return id, workerKey, nil
+		}
+	}
+	return 0, "", errNoFreeWorkerID
+}
+
+// keepAliveWorkerID 后台定期续约worker id占用的key，直到close被调用
+func (g *snowflakeGenerator) keepAliveWorkerID() {
+	ticker := time.NewTicker(snowflakeWorkerKeyRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, _ = g.backend.Expire(context.Background(), g.workerKey, snowflakeWorkerKeyTTL)
+		case <-g.stopCh:
+			return
+		}
+	}
+}
+
+// close 停止续约并释放worker id
+func (g *snowflakeGenerator) close() error {
+	g.stopOnce.Do(func() { close(g.stopCh) })
+	return g.backend.Delete(context.Background(), g.workerKey)
+}
+
+// nextID 生成下一个Snowflake id：1位符号位 + 41位毫秒时间戳 + 10位worker id + 12位序列号
+func (g *snowflakeGenerator) nextID() (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	if now < g.lastTimestamp {
+		return 0, errClockBackwards
+	}
+	if now == g.lastTimestamp {
+		g.sequence = (g.sequence + 1) & snowflakeMaxSequence
+		if g.sequence == 0 {
+			for now <= g.lastTimestamp {
+				now = time.Now().UnixMilli()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastTimestamp = now
+
+	id := (now-g.epochMs)<<snowflakeTimestampShift | g.workerID<<snowflakeWorkerIDShift | g.sequence
+	return id, nil
+}